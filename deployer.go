@@ -1,16 +1,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
+
+	"github.com/grep-michael/GoDeployer/logstore"
 )
 
 /*
@@ -22,12 +24,56 @@ const (
 	CONFIG_ID = "deploy.json"
 )
 
+// Mount is a host->container bind mount for DockerRuntime deploys.
+type Mount struct {
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	ReadOnly bool   `json:"read_only"`
+}
+
 type DeployConfig struct {
 	DeployLocation       string   `json:"deploy_location"`
 	Executable           string   `json:"executable"`
 	Args                 []string `json:"args"`
 	SourceLocation       string   `json:"source_location"` //location in share to copy source code from
 	EnvironmentVariables []string `json:"env_variables"`   //a list of strings in the format KEY=VALUE
+
+	// Image selects the DockerRuntime: when set, Executable/Args run
+	// inside a container of this image instead of directly on the host.
+	Image string `json:"image,omitempty"`
+	// Mounts are additional host->container bind mounts. The active
+	// release (DeployLocation/current) is always mounted read-write at
+	// that same path.
+	Mounts []Mount `json:"mounts,omitempty"`
+	// Network is the container network to attach to (docker network name).
+	Network string `json:"network,omitempty"`
+	// User is the "uid[:gid]" the container process runs as.
+	User string `json:"user,omitempty"`
+	// CapAdd/CapDrop are added/dropped Linux capabilities.
+	CapAdd  []string `json:"cap_add,omitempty"`
+	CapDrop []string `json:"cap_drop,omitempty"`
+	// GeneratePasswdEntry bind-mounts synthesized /etc/passwd and
+	// /etc/group entries for User, so the process isn't left running
+	// as a nameless "nobody" inside the container.
+	GeneratePasswdEntry bool `json:"generate_passwd_entry,omitempty"`
+
+	// KeepReleases is how many releases/ directories to retain after a
+	// successful deploy; defaults to defaultKeepCount.
+	KeepReleases int `json:"keep_releases,omitempty"`
+	// HealthCheck, if set, gates a deploy: a release that doesn't pass
+	// it within FailureThreshold attempts is automatically rolled back.
+	HealthCheck *HealthCheckConfig `json:"health_check,omitempty"`
+
+	// PreserveMetadata controls whether copyDir preserves the source's
+	// file mode, mtime, and uid/gid instead of copying plain bytes.
+	// Defaults to true; set to false explicitly to opt out.
+	PreserveMetadata *bool `json:"preserve_metadata,omitempty"`
+}
+
+// preserveMetadata reports whether copyDir should preserve file
+// metadata, defaulting to true when unset.
+func (c *DeployConfig) preserveMetadata() bool {
+	return c.PreserveMetadata == nil || *c.PreserveMetadata
 }
 
 func LoadDeployConfig(share string, panicOnFailure bool) *DeployConfig {
@@ -60,17 +106,35 @@ func LoadDeployConfig(share string, panicOnFailure bool) *DeployConfig {
 type Deployer struct {
 	MountLocation string
 	Config        *DeployConfig
-	currentCmd    *exec.Cmd
-	cmdMutex      sync.Mutex
-	isRunning     bool
+	KillGrace     time.Duration
+
+	ctx context.Context
+
+	runtimeMutex sync.Mutex
+	runtime      Runtime
+
+	Logs *logstore.LogStore
 }
 
-func NewDeployer(mountLocation string) *Deployer {
+func NewDeployer(ctx context.Context, mountLocation string, killGrace time.Duration, logs *logstore.LogStore) *Deployer {
 	cfg := LoadDeployConfig(mountLocation, true)
 	deployer := &Deployer{
 		MountLocation: mountLocation,
 		Config:        cfg,
+		KillGrace:     killGrace,
+		ctx:           ctx,
+		Logs:          logs,
 	}
+
+	// Honour shutdown even if nothing else calls Kill in time. Registered
+	// once here rather than per startReleaseLocked, since a long-running
+	// deployer can go through many Deploy/Redeploy/Rollback cycles.
+	go func() {
+		<-ctx.Done()
+		log.Println("Shutdown requested, killing deployed process...")
+		deployer.Kill()
+	}()
+
 	return deployer
 }
 
@@ -78,7 +142,10 @@ func (d *Deployer) Handle(event FileChangeEvent) {
 	fmt.Println(event)
 	if event.RelPath == CONFIG_ID {
 		d.reloadConfig()
-		d.Redeploy()
+		// A config change (executable, args, image, health check, ...)
+		// must restart the process even if the source content itself
+		// didn't change.
+		d.redeploy(true)
 		return
 	}
 	if d.isSourceFile(event.RelPath) {
@@ -103,104 +170,108 @@ func (d *Deployer) reloadConfig() {
 	}
 }
 
+// Kill stops whatever Runtime is currently deployed, giving it up to
+// d.KillGrace to exit gracefully before it's forced to stop.
 func (d *Deployer) Kill() error {
-	d.cmdMutex.Lock()
-	defer d.cmdMutex.Unlock()
+	d.runtimeMutex.Lock()
+	defer d.runtimeMutex.Unlock()
+	return d.killLocked()
+}
 
-	if d.currentCmd == nil || d.currentCmd.Process == nil {
+func (d *Deployer) killLocked() error {
+	if d.runtime == nil {
 		log.Println("No process to kill")
 		return nil
 	}
-
-	log.Printf("Killing process PID: %d", d.currentCmd.Process.Pid)
-
-	// Send SIGTERM for graceful shutdown
-	if err := d.currentCmd.Process.Signal(syscall.SIGTERM); err != nil {
-		log.Printf("Failed to send SIGTERM: %v", err)
-		// Force kill if SIGTERM fails
-		if err := d.currentCmd.Process.Kill(); err != nil {
-			return fmt.Errorf("failed to kill process: %w", err)
-		}
-	}
-
-	// Wait for process to exit (with timeout)
-	done := make(chan error, 1)
-	go func() {
-		done <- d.currentCmd.Wait()
-	}()
-
-	select {
-	case <-done:
-		log.Println("Process terminated successfully")
-	case <-time.After(5 * time.Second):
-		log.Println("Process didn't exit gracefully, force killing...")
-		d.currentCmd.Process.Kill()
-	}
-
-	d.currentCmd = nil
-	d.isRunning = false
-	return nil
+	return d.runtime.Stop(context.Background(), d.KillGrace)
 }
 
+// Deploy copies the current source tree into a fresh
+// DeployLocation/releases/<id>/ directory, atomically flips the
+// `current` symlink at it, starts it, and - if a HealthCheck is
+// configured - rolls back to the previous release when it fails to
+// come up healthy.
 func (d *Deployer) Deploy() error {
-	d.cmdMutex.Lock()
-	defer d.cmdMutex.Unlock()
+	d.runtimeMutex.Lock()
+	defer d.runtimeMutex.Unlock()
 
-	// Copy source files
 	sourcePath := filepath.Join(d.MountLocation, d.Config.SourceLocation)
-	deployPath := d.Config.DeployLocation
+	deployLocation := d.Config.DeployLocation
 
-	log.Printf("Copying from %s to %s", sourcePath, deployPath)
-	if err := copyDir(sourcePath, deployPath); err != nil {
-		return fmt.Errorf("failed to copy source: %w", err)
+	sourceHash, err := hashDir(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to hash source: %w", err)
 	}
-	log.Printf("Starting executable: %s %v", d.Config.Executable, d.Config.Args)
-
-	//set up env
-	env := os.Environ()
-	env = append(env, d.Config.EnvironmentVariables...)
 
-	// Add display variables for X11
-	env = append(env, "DISPLAY=:0") // Primary display
-	env = append(env, fmt.Sprintf("XAUTHORITY=/home/%s/.Xauthority", os.Getenv("USER")))
+	releaseID := newReleaseID()
+	releasePath := filepath.Join(releasesDir(deployLocation), releaseID)
 
-	// Create command
-	cmd := exec.Command(d.Config.Executable, d.Config.Args...)
-	cmd.Dir = deployPath
-	cmd.Env = env
+	log.Printf("Copying from %s to release %s", sourcePath, releaseID)
+	if err := copyDir(sourcePath, releasePath, d.Config.preserveMetadata()); err != nil {
+		return fmt.Errorf("failed to copy source: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(releasePath, sourceHashFile), []byte(sourceHash), 0644); err != nil {
+		return fmt.Errorf("failed to record source hash: %w", err)
+	}
 
-	// Pipe output to logs
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	previousID, _ := currentReleaseID(deployLocation)
 
-	// Start the process
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start executable: %w", err)
+	if err := flipCurrent(deployLocation, releaseID); err != nil {
+		return fmt.Errorf("failed to activate release %s: %w", releaseID, err)
 	}
 
-	d.currentCmd = cmd
-	d.isRunning = true
+	if err := d.startReleaseLocked(releaseID); err != nil {
+		return err
+	}
 
-	log.Printf("Process started with PID: %d", cmd.Process.Pid)
+	if runHealthCheck(d.ctx, d.Config.HealthCheck) {
+		// Only safe to prune once the new release is confirmed healthy:
+		// pruning earlier could remove previousID before a failed health
+		// check gets a chance to roll back to it.
+		pruneReleases(deployLocation, d.Config.KeepReleases)
+		return nil
+	}
 
-	// Monitor process in goroutine
-	go func() {
-		err := cmd.Wait()
-		d.cmdMutex.Lock()
-		d.isRunning = false
-		d.cmdMutex.Unlock()
+	msg := fmt.Sprintf("deploy_failed: release %s did not pass its health check", releaseID)
+	log.Println(msg)
+	fmt.Fprintln(d.Logs, msg)
 
-		if err != nil {
-			log.Printf("Process exited with error: %v", err)
-		} else {
-			log.Println("Process exited normally")
+	if previousID == "" {
+		if err := d.killLocked(); err != nil {
+			log.Printf("Error killing unhealthy release %s: %v", releaseID, err)
 		}
-	}()
-
-	return nil
+		return fmt.Errorf("%s and there is no previous release to roll back to", msg)
+	}
+	return d.rollbackToLocked(previousID)
 }
 
+// Redeploy re-checks whether SourceLocation's content actually changed
+// since the active release, and if so kills the running process and
+// deploys the new source.
 func (d *Deployer) Redeploy() error {
+	return d.redeploy(false)
+}
+
+// redeploy does the work behind Redeploy. When force is true (a
+// deploy.json change) it always restarts, since the source content
+// hash can't tell us Executable/Args/Image/HealthCheck/etc changed.
+// When force is false (a source file change) it skips the restart if
+// SourceLocation's content hash is unchanged.
+func (d *Deployer) redeploy(force bool) error {
+	changed := true
+	if !force {
+		var err error
+		changed, err = d.sourceChanged()
+		if err != nil {
+			log.Printf("Error checking source hash, redeploying to be safe: %v", err)
+			changed = true
+		}
+	}
+	if !changed {
+		log.Println("Source unchanged, skipping redeploy")
+		return nil
+	}
+
 	log.Println("Starting redeployment...")
 
 	// Kill existing process
@@ -214,17 +285,105 @@ func (d *Deployer) Redeploy() error {
 	// Deploy new version
 	return d.Deploy()
 }
+
+// Rollback flips `current` back to the release before it and restarts
+// the process against that release.
+func (d *Deployer) Rollback() error {
+	d.runtimeMutex.Lock()
+	defer d.runtimeMutex.Unlock()
+
+	deployLocation := d.Config.DeployLocation
+	current, err := currentReleaseID(deployLocation)
+	if err != nil {
+		return fmt.Errorf("failed to resolve current release: %w", err)
+	}
+
+	previous, err := previousReleaseID(deployLocation, current)
+	if err != nil {
+		return err
+	}
+
+	return d.rollbackToLocked(previous)
+}
+
+// rollbackToLocked kills the active release, flips `current` to
+// previousID, and restarts against it. Callers must hold runtimeMutex.
+func (d *Deployer) rollbackToLocked(previousID string) error {
+	if err := d.killLocked(); err != nil {
+		log.Printf("Error killing release before rollback: %v", err)
+	}
+
+	if err := flipCurrent(d.Config.DeployLocation, previousID); err != nil {
+		return fmt.Errorf("failed to roll back to release %s: %w", previousID, err)
+	}
+
+	log.Printf("Rolled back to release %s", previousID)
+	return d.startReleaseLocked(previousID)
+}
+
+// startReleaseLocked starts the Runtime for releaseID against the
+// `current` symlink. Callers must hold runtimeMutex.
+func (d *Deployer) startReleaseLocked(releaseID string) error {
+	deployPath := currentLink(d.Config.DeployLocation)
+
+	annotation := fmt.Sprintf("deploy started %s, release=%s, source=%s",
+		time.Now().Format(time.RFC3339), releaseID, d.Config.SourceLocation)
+	if err := d.Logs.NewSegment(annotation); err != nil {
+		log.Printf("Failed to start new log segment: %v", err)
+	}
+
+	rt := newRuntime(d.Config)
+	if err := rt.Start(d.ctx, d.Config, deployPath, d.Logs); err != nil {
+		return err
+	}
+	d.runtime = rt
+
+	return nil
+}
+
+// sourceChanged reports whether SourceLocation's content hash differs
+// from the hash recorded for the currently active release, so touching
+// a file without changing its content doesn't trigger a redeploy.
+func (d *Deployer) sourceChanged() (bool, error) {
+	sourcePath := filepath.Join(d.MountLocation, d.Config.SourceLocation)
+	hash, err := hashDir(sourcePath)
+	if err != nil {
+		return true, err
+	}
+
+	deployLocation := d.Config.DeployLocation
+	currentID, err := currentReleaseID(deployLocation)
+	if err != nil {
+		return true, err
+	}
+	if currentID == "" {
+		return true, nil
+	}
+
+	existing, err := os.ReadFile(filepath.Join(releasesDir(deployLocation), currentID, sourceHashFile))
+	if err != nil {
+		return true, nil
+	}
+
+	return string(existing) != hash, nil
+}
+
 func (d *Deployer) IsRunning() bool {
-	d.cmdMutex.Lock()
-	defer d.cmdMutex.Unlock()
-	return d.isRunning
+	d.runtimeMutex.Lock()
+	rt := d.runtime
+	d.runtimeMutex.Unlock()
+
+	if rt == nil {
+		return false
+	}
+	return rt.Running()
 }
 
 //	--------------
 //	Help functions
 //	--------------
 
-func copyDir(src, dst string) error {
+func copyDir(src, dst string, preserveMetadata bool) error {
 	// Create destination directory
 	if err := os.MkdirAll(dst, 0755); err != nil {
 		return err
@@ -248,15 +407,71 @@ func copyDir(src, dst string) error {
 		}
 
 		// Copy file
-		return copyFile(path, dstPath)
+		return copyFile(path, dstPath, info, preserveMetadata)
 	})
 }
 
-func copyFile(src, dst string) error {
-	data, err := os.ReadFile(src)
+// copyFile streams src to dst via io.Copy so large files aren't loaded
+// into memory, then optionally preserves src's mode, mtime, and uid/gid
+// so an Executable doesn't lose its exec bit (or a config its owner) on
+// the way into a release. Both the destination file and its parent
+// directory are fsync'd before returning so a crash right after Deploy
+// can't leave a truncated file on disk.
+func copyFile(src, dst string, info os.FileInfo, preserveMetadata bool) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	mode := os.FileMode(0644)
+	if preserveMetadata {
+		mode = info.Mode().Perm()
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(dst, data, 0644)
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	if preserveMetadata {
+		if err := out.Chmod(mode); err != nil {
+			out.Close()
+			return err
+		}
+		chownToSource(out, info)
+	}
+
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	if preserveMetadata {
+		modTime := info.ModTime()
+		if err := os.Chtimes(dst, modTime, modTime); err != nil {
+			return err
+		}
+	}
+
+	return fsyncDir(filepath.Dir(dst))
+}
+
+// fsyncDir fsyncs a directory so a rename or file creation within it is
+// durable, not just the file contents.
+func fsyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
 }
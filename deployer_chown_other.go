@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// chownToSource is a no-op on platforms without Unix uid/gid ownership.
+func chownToSource(dst *os.File, info os.FileInfo) {}
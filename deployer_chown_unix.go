@@ -0,0 +1,22 @@
+//go:build unix
+
+package main
+
+import (
+	"log"
+	"os"
+	"syscall"
+)
+
+// chownToSource best-effort preserves src's uid/gid on the already-open
+// destination file. Failures are logged, not returned, since the agent
+// commonly runs as a non-root user that can't chown to arbitrary owners.
+func chownToSource(dst *os.File, info os.FileInfo) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	if err := dst.Chown(int(stat.Uid), int(stat.Gid)); err != nil {
+		log.Printf("Failed to preserve ownership of %s: %v", dst.Name(), err)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/grep-michael/GoDeployer/logstore"
+)
+
+// DockerRuntime runs the deployed build inside a container instead of
+// directly on the host, using the Docker Engine API.
+type DockerRuntime struct {
+	mu          sync.Mutex
+	cli         *client.Client
+	containerID string
+	exited      chan struct{}
+	running     bool
+	cleanup     func()
+}
+
+func NewDockerRuntime() *DockerRuntime {
+	return &DockerRuntime{}
+}
+
+func (r *DockerRuntime) Start(ctx context.Context, cfg *DeployConfig, deployPath string, logs *logstore.LogStore) error {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return fmt.Errorf("failed to create docker client: %w", err)
+	}
+
+	binds := []string{fmt.Sprintf("%s:%s", deployPath, deployPath)}
+	for _, m := range cfg.Mounts {
+		bind := fmt.Sprintf("%s:%s", m.Source, m.Target)
+		if m.ReadOnly {
+			bind += ":ro"
+		}
+		binds = append(binds, bind)
+	}
+
+	var cleanup func()
+	if cfg.GeneratePasswdEntry && cfg.User != "" {
+		passwdPath, groupPath, cleanupFiles, err := generatePasswdFiles(cfg.User)
+		if err != nil {
+			return fmt.Errorf("failed to generate passwd/group entries: %w", err)
+		}
+		binds = append(binds,
+			fmt.Sprintf("%s:/etc/passwd:ro", passwdPath),
+			fmt.Sprintf("%s:/etc/group:ro", groupPath),
+		)
+		cleanup = cleanupFiles
+	}
+
+	log.Printf("Pulling image %s...", cfg.Image)
+	if out, err := cli.ImagePull(ctx, cfg.Image, types.ImagePullOptions{}); err != nil {
+		log.Printf("Warning: failed to pull image %s: %v", cfg.Image, err)
+	} else {
+		io.Copy(io.Discard, out)
+		out.Close()
+	}
+
+	containerCfg := &container.Config{
+		Image:      cfg.Image,
+		Cmd:        append([]string{cfg.Executable}, cfg.Args...),
+		Env:        cfg.EnvironmentVariables,
+		WorkingDir: deployPath,
+		User:       cfg.User,
+	}
+
+	hostCfg := &container.HostConfig{
+		Binds:       binds,
+		NetworkMode: container.NetworkMode(cfg.Network),
+		CapAdd:      cfg.CapAdd,
+		CapDrop:     cfg.CapDrop,
+	}
+
+	containerName := fmt.Sprintf("godeployer-%d", time.Now().UnixNano())
+	created, err := cli.ContainerCreate(ctx, containerCfg, hostCfg, nil, nil, containerName)
+	if err != nil {
+		if cleanup != nil {
+			cleanup()
+		}
+		return fmt.Errorf("failed to create container: %w", err)
+	}
+
+	attach, err := cli.ContainerAttach(ctx, created.ID, types.ContainerAttachOptions{
+		Stream: true,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		if cleanup != nil {
+			cleanup()
+		}
+		return fmt.Errorf("failed to attach to container: %w", err)
+	}
+
+	if err := cli.ContainerStart(ctx, created.ID, types.ContainerStartOptions{}); err != nil {
+		attach.Close()
+		if cleanup != nil {
+			cleanup()
+		}
+		return fmt.Errorf("failed to start container: %w", err)
+	}
+
+	log.Printf("Container started: %s (%s)", containerName, created.ID)
+
+	go func() {
+		defer attach.Close()
+		stdcopy.StdCopy(logs, logs, attach.Reader)
+	}()
+
+	exited := make(chan struct{})
+	r.mu.Lock()
+	r.cli = cli
+	r.containerID = created.ID
+	r.exited = exited
+	r.running = true
+	r.cleanup = cleanup
+	r.mu.Unlock()
+
+	go func() {
+		waitCh, errCh := cli.ContainerWait(context.Background(), created.ID, container.WaitConditionNotRunning)
+		select {
+		case err := <-errCh:
+			log.Printf("Error waiting on container %s: %v", created.ID, err)
+		case status := <-waitCh:
+			log.Printf("Container %s exited with status %d", created.ID, status.StatusCode)
+		}
+		r.mu.Lock()
+		r.running = false
+		r.mu.Unlock()
+		close(exited)
+	}()
+
+	return nil
+}
+
+func (r *DockerRuntime) Stop(ctx context.Context, grace time.Duration) error {
+	r.mu.Lock()
+	cli := r.cli
+	containerID := r.containerID
+	exited := r.exited
+	cleanup := r.cleanup
+	r.mu.Unlock()
+
+	if cli == nil || containerID == "" {
+		log.Println("No process to kill")
+		return nil
+	}
+
+	log.Printf("Stopping container %s", containerID)
+
+	if err := cli.ContainerStop(ctx, containerID, &grace); err != nil {
+		log.Printf("Failed to stop container gracefully: %v", err)
+	} else if exited != nil {
+		<-exited
+	}
+
+	if err := cli.ContainerRemove(ctx, containerID, types.ContainerRemoveOptions{Force: true}); err != nil {
+		log.Printf("Failed to remove container %s: %v", containerID, err)
+	}
+
+	if cleanup != nil {
+		cleanup()
+	}
+	cli.Close()
+
+	r.mu.Lock()
+	if r.containerID == containerID {
+		r.cli = nil
+		r.containerID = ""
+		r.exited = nil
+		r.running = false
+		r.cleanup = nil
+	}
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *DockerRuntime) Running() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.running
+}
+
+// generatePasswdFiles writes minimal /etc/passwd and /etc/group files
+// containing a single entry for user (in "uid[:gid]" form) so the
+// container's process isn't left running as a nameless "nobody".
+func generatePasswdFiles(user string) (passwdPath, groupPath string, cleanup func(), err error) {
+	uid := user
+	gid := user
+	if idx := strings.Index(user, ":"); idx != -1 {
+		uid = user[:idx]
+		gid = user[idx+1:]
+	}
+
+	dir, err := os.MkdirTemp("", "godeployer-passwd-")
+	if err != nil {
+		return "", "", nil, err
+	}
+	cleanup = func() { os.RemoveAll(dir) }
+
+	passwdPath = filepath.Join(dir, "passwd")
+	passwdEntry := fmt.Sprintf("deployed:x:%s:%s::/:/sbin/nologin\n", uid, gid)
+	if err := os.WriteFile(passwdPath, []byte(passwdEntry), 0644); err != nil {
+		cleanup()
+		return "", "", nil, err
+	}
+
+	groupPath = filepath.Join(dir, "group")
+	groupEntry := fmt.Sprintf("deployed:x:%s:\n", gid)
+	if err := os.WriteFile(groupPath, []byte(groupEntry), 0644); err != nil {
+		cleanup()
+		return "", "", nil, err
+	}
+
+	return passwdPath, groupPath, cleanup, nil
+}
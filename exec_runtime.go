@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/grep-michael/GoDeployer/logstore"
+)
+
+// ExecRuntime runs the deployed executable directly on the host via
+// fork/exec - the original (and default) GoDeployer behaviour.
+type ExecRuntime struct {
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	exited  chan struct{}
+	running bool
+}
+
+func NewExecRuntime() *ExecRuntime {
+	return &ExecRuntime{}
+}
+
+func (r *ExecRuntime) Start(_ context.Context, cfg *DeployConfig, deployPath string, logs *logstore.LogStore) error {
+	log.Printf("Starting executable: %s %v", cfg.Executable, cfg.Args)
+
+	//set up env
+	env := os.Environ()
+	env = append(env, cfg.EnvironmentVariables...)
+
+	// Add display variables for X11
+	env = append(env, "DISPLAY=:0") // Primary display
+	env = append(env, fmt.Sprintf("XAUTHORITY=/home/%s/.Xauthority", os.Getenv("USER")))
+
+	cmd := exec.Command(cfg.Executable, cfg.Args...)
+	cmd.Dir = deployPath
+	cmd.Env = env
+
+	// Pipe output to the log store instead of directly to the terminal
+	cmd.Stdout = logs
+	cmd.Stderr = logs
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start executable: %w", err)
+	}
+
+	exited := make(chan struct{})
+	r.mu.Lock()
+	r.cmd = cmd
+	r.exited = exited
+	r.running = true
+	r.mu.Unlock()
+
+	log.Printf("Process started with PID: %d", cmd.Process.Pid)
+
+	// Monitor process in goroutine
+	go func() {
+		err := cmd.Wait()
+		r.mu.Lock()
+		r.running = false
+		r.mu.Unlock()
+
+		if err != nil {
+			log.Printf("Process exited with error: %v", err)
+		} else {
+			log.Println("Process exited normally")
+		}
+		close(exited)
+	}()
+
+	return nil
+}
+
+func (r *ExecRuntime) Stop(ctx context.Context, grace time.Duration) error {
+	r.mu.Lock()
+	cmd := r.cmd
+	exited := r.exited
+	r.mu.Unlock()
+
+	if cmd == nil || cmd.Process == nil {
+		log.Println("No process to kill")
+		return nil
+	}
+
+	log.Printf("Killing process PID: %d", cmd.Process.Pid)
+
+	// Send SIGTERM for graceful shutdown
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		log.Printf("Failed to send SIGTERM: %v", err)
+		// Force kill if SIGTERM fails
+		if err := cmd.Process.Kill(); err != nil {
+			return fmt.Errorf("failed to kill process: %w", err)
+		}
+	}
+
+	graceCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	select {
+	case <-exited:
+		log.Println("Process terminated successfully")
+	case <-graceCtx.Done():
+		log.Println("Process didn't exit gracefully, force killing...")
+		cmd.Process.Kill()
+		<-exited
+	}
+
+	r.mu.Lock()
+	if r.cmd == cmd {
+		r.cmd = nil
+		r.exited = nil
+		r.running = false
+	}
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *ExecRuntime) Running() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.running
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"time"
+)
+
+// HealthCheckConfig describes how to confirm a freshly deployed release
+// actually came up healthy, before it's trusted over the previous one.
+type HealthCheckConfig struct {
+	Command          string   `json:"command,omitempty"`           // shell command; exit 0 means healthy
+	HTTP             string   `json:"http,omitempty"`              // URL to GET; ExpectedStatus means healthy
+	ExpectedStatus   int      `json:"expected_status,omitempty"`   // defaults to 200
+	InitialDelay     Duration `json:"initial_delay,omitempty"`     // wait before the first check
+	Interval         Duration `json:"interval,omitempty"`          // wait between retries
+	FailureThreshold int      `json:"failure_threshold,omitempty"` // consecutive failures before giving up
+}
+
+// Duration is a time.Duration that unmarshals from JSON as either a
+// plain nanosecond count or a time.ParseDuration string like "5s", so
+// deploy.json can write "initial_delay": "5s" instead of counting out
+// nanoseconds by hand.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	switch value := v.(type) {
+	case float64:
+		*d = Duration(value)
+	case string:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %w", value, err)
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("invalid duration: %v", v)
+	}
+	return nil
+}
+
+// runHealthCheck waits InitialDelay, then polls the configured check
+// every Interval until it passes or FailureThreshold consecutive
+// failures have been observed. A nil hc always passes.
+func runHealthCheck(ctx context.Context, hc *HealthCheckConfig) bool {
+	if hc == nil {
+		return true
+	}
+
+	if hc.InitialDelay > 0 {
+		select {
+		case <-time.After(time.Duration(hc.InitialDelay)):
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	interval := time.Duration(hc.Interval)
+	if interval <= 0 {
+		interval = time.Second
+	}
+	threshold := hc.FailureThreshold
+	if threshold <= 0 {
+		threshold = 1
+	}
+
+	for attempt := 1; attempt <= threshold; attempt++ {
+		if checkOnce(ctx, hc) {
+			return true
+		}
+		if attempt == threshold {
+			break
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return false
+}
+
+func checkOnce(ctx context.Context, hc *HealthCheckConfig) bool {
+	switch {
+	case hc.Command != "":
+		return exec.CommandContext(ctx, "sh", "-c", hc.Command).Run() == nil
+
+	case hc.HTTP != "":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, hc.HTTP, nil)
+		if err != nil {
+			return false
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+
+		expected := hc.ExpectedStatus
+		if expected == 0 {
+			expected = http.StatusOK
+		}
+		return resp.StatusCode == expected
+
+	default:
+		return true
+	}
+}
@@ -0,0 +1,58 @@
+package logstore
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Handler returns an http.Handler serving GET /logs?tail=N&follow=1:
+// it writes the last N buffered lines (all of them if N <= 0), then (if follow=1) keeps the
+// connection open and streams new lines as they arrive, similar to
+// `docker logs -f`.
+func (s *LogStore) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/logs", s.handleLogs)
+	return mux
+}
+
+func (s *LogStore) handleLogs(w http.ResponseWriter, r *http.Request) {
+	tail := 100
+	if raw := r.URL.Query().Get("tail"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil {
+			tail = n
+		}
+	}
+	follow := r.URL.Query().Get("follow") == "1"
+
+	flusher, canFlush := w.(http.Flusher)
+
+	for _, line := range s.Tail(tail) {
+		fmt.Fprintln(w, line)
+	}
+	if canFlush {
+		flusher.Flush()
+	}
+
+	if !follow {
+		return
+	}
+
+	ch, unsubscribe := s.Follow()
+	defer unsubscribe()
+
+	for {
+		select {
+		case line, ok := <-ch:
+			if !ok {
+				return
+			}
+			fmt.Fprintln(w, line)
+			if canFlush {
+				flusher.Flush()
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,294 @@
+// Package logstore captures a deployed process's stdout/stderr to
+// rotated, gzipped files on disk while keeping the most recent lines in
+// memory so callers (and the follow HTTP endpoint) can fetch recent
+// output cheaply without re-reading from disk.
+package logstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Config controls rotation and retention for a LogStore.
+type Config struct {
+	Directory    string // where log segments are written
+	MaxFileBytes int64  // rotate once the active segment passes this size
+	MaxFiles     int    // rotated (gzipped) segments to keep, oldest deleted first
+	RingSize     int    // lines kept in memory for Tail/follow
+}
+
+// LogStore is an io.Writer that timestamps each line written to it,
+// persists it to a rotating file under Directory, keeps the last
+// RingSize lines in memory, and fans them out to any followers.
+type LogStore struct {
+	cfg Config
+
+	mu      sync.Mutex
+	file    *os.File
+	written int64
+	partial []byte // bytes received since the last newline
+
+	ring      []string
+	ringStart int // index of the oldest line in ring, once it's full
+
+	subMu       sync.Mutex
+	subscribers map[chan string]struct{}
+}
+
+// New creates Directory if needed and opens (or creates) the active
+// log segment.
+func New(cfg Config) (*LogStore, error) {
+	if cfg.MaxFileBytes <= 0 {
+		cfg.MaxFileBytes = 10 * 1024 * 1024
+	}
+	if cfg.MaxFiles <= 0 {
+		cfg.MaxFiles = 5
+	}
+	if cfg.RingSize <= 0 {
+		cfg.RingSize = 1000
+	}
+
+	if err := os.MkdirAll(cfg.Directory, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	s := &LogStore{
+		cfg:         cfg,
+		ring:        make([]string, 0, cfg.RingSize),
+		subscribers: make(map[chan string]struct{}),
+	}
+
+	if err := s.openSegment(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *LogStore) segmentPath() string {
+	return filepath.Join(s.cfg.Directory, "current.log")
+}
+
+// openSegment must be called with s.mu held or before any other goroutine
+// has a reference to s.
+func (s *LogStore) openSegment() error {
+	f, err := os.OpenFile(s.segmentPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log segment: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat log segment: %w", err)
+	}
+	s.file = f
+	s.written = info.Size()
+	return nil
+}
+
+// NewSegment rotates to a fresh log file and writes annotation as the
+// first line, so crashes can be correlated with a specific deploy.
+func (s *LogStore) NewSegment(annotation string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.rotateLocked(); err != nil {
+		return err
+	}
+
+	s.writeLineLocked(fmt.Sprintf("=== %s ===", annotation))
+	return nil
+}
+
+// Write implements io.Writer. Cmd.Stdout/Cmd.Stderr may call it with
+// arbitrary chunks, so partial lines are buffered until a newline shows up.
+func (s *LogStore) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.partial = append(s.partial, p...)
+	for {
+		idx := bytes.IndexByte(s.partial, '\n')
+		if idx < 0 {
+			break
+		}
+		line := string(bytes.TrimRight(s.partial[:idx], "\r"))
+		s.partial = s.partial[idx+1:]
+		s.writeLineLocked(line)
+	}
+	return len(p), nil
+}
+
+// writeLineLocked timestamps, persists, buffers and broadcasts a single
+// line. Callers must hold s.mu.
+func (s *LogStore) writeLineLocked(line string) {
+	stamped := fmt.Sprintf("%s %s", time.Now().Format(time.RFC3339Nano), line)
+
+	if s.file != nil {
+		n, err := fmt.Fprintln(s.file, stamped)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "logstore: failed to write log line: %v\n", err)
+		} else {
+			s.written += int64(n)
+		}
+		if s.written >= s.cfg.MaxFileBytes {
+			if err := s.rotateLocked(); err != nil {
+				fmt.Fprintf(os.Stderr, "logstore: failed to rotate log: %v\n", err)
+			}
+		}
+	}
+
+	s.appendRing(stamped)
+	s.broadcast(stamped)
+}
+
+func (s *LogStore) appendRing(line string) {
+	if len(s.ring) < s.cfg.RingSize {
+		s.ring = append(s.ring, line)
+		return
+	}
+	s.ring[s.ringStart] = line
+	s.ringStart = (s.ringStart + 1) % s.cfg.RingSize
+}
+
+// Tail returns up to n of the most recent lines, oldest first. n <= 0
+// returns every buffered line.
+func (s *LogStore) Tail(n int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := len(s.ring)
+	if n <= 0 || n > total {
+		n = total
+	}
+
+	out := make([]string, 0, n)
+	for i := total - n; i < total; i++ {
+		out = append(out, s.ring[(s.ringStart+i)%s.cfg.RingSize])
+	}
+	return out
+}
+
+// Follow registers a new subscriber and returns a channel of future
+// lines plus an unsubscribe func. Callers should drain the channel
+// promptly; a slow reader's lines are dropped rather than blocking
+// writers.
+func (s *LogStore) Follow() (<-chan string, func()) {
+	ch := make(chan string, 256)
+
+	s.subMu.Lock()
+	s.subscribers[ch] = struct{}{}
+	s.subMu.Unlock()
+
+	unsubscribe := func() {
+		s.subMu.Lock()
+		delete(s.subscribers, ch)
+		s.subMu.Unlock()
+	}
+
+	return ch, unsubscribe
+}
+
+func (s *LogStore) broadcast(line string) {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+
+	for ch := range s.subscribers {
+		select {
+		case ch <- line:
+		default:
+			// Subscriber fell behind; drop the line rather than block
+			// the deployed process's own output.
+		}
+	}
+}
+
+// rotateLocked closes and gzips the active segment, evicts the oldest
+// rotated segments past MaxFiles, and opens a fresh active segment.
+// Callers must hold s.mu.
+func (s *LogStore) rotateLocked() error {
+	if s.file != nil {
+		if err := s.file.Close(); err != nil {
+			return fmt.Errorf("failed to close log segment: %w", err)
+		}
+
+		if s.written > 0 {
+			if err := gzipFile(s.segmentPath()); err != nil {
+				return fmt.Errorf("failed to gzip log segment: %w", err)
+			}
+		} else {
+			os.Remove(s.segmentPath())
+		}
+	}
+
+	if err := s.evictOldLocked(); err != nil {
+		return err
+	}
+
+	return s.openSegment()
+}
+
+func gzipFile(path string) error {
+	rotatedPath := fmt.Sprintf("%s.%s.gz", path, time.Now().Format("20060102T150405.000000000"))
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(rotatedPath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	gw := gzip.NewWriter(dst)
+	if _, err := io.Copy(gw, src); err != nil {
+		gw.Close()
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// evictOldLocked removes rotated (*.gz) segments beyond cfg.MaxFiles,
+// oldest first. Callers must hold s.mu.
+func (s *LogStore) evictOldLocked() error {
+	matches, err := filepath.Glob(filepath.Join(s.cfg.Directory, "current.log.*.gz"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(matches) // timestamp-suffixed names sort chronologically
+
+	excess := len(matches) - s.cfg.MaxFiles
+	for i := 0; i < excess; i++ {
+		if err := os.Remove(matches[i]); err != nil {
+			fmt.Fprintf(os.Stderr, "logstore: failed to evict %s: %v\n", matches[i], err)
+		}
+	}
+	return nil
+}
+
+// Close closes the active log segment.
+func (s *LogStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
+	"syscall"
 	"time"
+
+	"github.com/grep-michael/GoDeployer/logstore"
 )
 
 func unmountShare(mountPoint string) {
@@ -27,10 +33,39 @@ func main() {
 	password := flag.String("pass", "admin", "SMB password")
 	shareType := flag.String("type", "cifs", "Share type")
 	pollInterval := flag.Int("interval", 5, "Poll interval in seconds")
+	killGrace := flag.Duration("kill-grace", 5*time.Second, "How long to wait for the deployed process to exit after SIGTERM before SIGKILL")
+	logDir := flag.String("log-dir", "./logs", "Directory to persist deployed process logs under")
+	logMaxBytes := flag.Int64("log-max-bytes", 10*1024*1024, "Rotate the active log segment once it passes this size")
+	logMaxFiles := flag.Int("log-max-files", 5, "Number of rotated (gzipped) log segments to keep")
+	logRingLines := flag.Int("log-ring-lines", 1000, "Number of recent log lines to keep in memory for GET /logs")
+	listenAddr := flag.String("listen", "", "Address to serve GET /logs?tail=N&follow=1 on (disabled if empty)")
 
 	// Parse command line flags
 	flag.Parse()
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logStore, err := logstore.New(logstore.Config{
+		Directory:    *logDir,
+		MaxFileBytes: *logMaxBytes,
+		MaxFiles:     *logMaxFiles,
+		RingSize:     *logRingLines,
+	})
+	if err != nil {
+		log.Fatalf("Failed to open log store: %v", err)
+	}
+	defer logStore.Close()
+
+	if *listenAddr != "" {
+		go func() {
+			log.Printf("Serving logs on http://%s/logs", *listenAddr)
+			if err := http.ListenAndServe(*listenAddr, logStore.Handler()); err != nil {
+				log.Printf("log HTTP server stopped: %v", err)
+			}
+		}()
+	}
+
 	//make mount point locally
 	os.MkdirAll(*shareLocalName, 0755)
 
@@ -42,13 +77,14 @@ func main() {
 	if err := cmd.Run(); err != nil {
 		log.Fatalf("Failed to mount share: %v", err)
 	}
+	defer unmountShare(*shareLocalName)
 
 	log.Printf("Mounted %s to %s", *shareServer, *shareLocalName)
 
-	deployer := NewDeployer(*shareLocalName)
+	deployer := NewDeployer(ctx, *shareLocalName, *killGrace, logStore)
+	defer deployer.Kill()
 
-	filewatcher := NewFileWatcher(*shareLocalName, time.Duration(*pollInterval)*time.Second)
+	filewatcher := NewFileWatcher(ctx, *shareLocalName, time.Duration(*pollInterval)*time.Second)
 	filewatcher.Subscribe(deployer.Handle)
-	filewatcher.StartPolling()
-
+	filewatcher.Start()
 }
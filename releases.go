@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// releases.go manages DeployLocation/releases/<id>/ directories and the
+// DeployLocation/current symlink that atomically flips between them, so
+// a crash mid-copy never leaves a half-deployed tree live.
+
+const (
+	releasesDirName  = "releases"
+	currentLinkName  = "current"
+	sourceHashFile   = ".source-hash"
+	defaultKeepCount = 5
+)
+
+func releasesDir(deployLocation string) string {
+	return filepath.Join(deployLocation, releasesDirName)
+}
+
+func currentLink(deployLocation string) string {
+	return filepath.Join(deployLocation, currentLinkName)
+}
+
+// newReleaseID returns an identifier for a new release directory,
+// lexically sortable by time so releases/ can be walked oldest-first.
+func newReleaseID() string {
+	return time.Now().UTC().Format("20060102T150405.000000000")
+}
+
+// currentReleaseID resolves the release the `current` symlink points
+// at, or "" if no release has been activated yet.
+func currentReleaseID(deployLocation string) (string, error) {
+	target, err := os.Readlink(currentLink(deployLocation))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return filepath.Base(target), nil
+}
+
+// sortedReleaseIDs lists every release directory under deployLocation,
+// oldest first.
+func sortedReleaseIDs(deployLocation string) ([]string, error) {
+	entries, err := os.ReadDir(releasesDir(deployLocation))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var ids []string
+	for _, e := range entries {
+		if e.IsDir() {
+			ids = append(ids, e.Name())
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// previousReleaseID returns the release immediately before current, for
+// Rollback.
+func previousReleaseID(deployLocation, current string) (string, error) {
+	ids, err := sortedReleaseIDs(deployLocation)
+	if err != nil {
+		return "", err
+	}
+	for i, id := range ids {
+		if id == current && i > 0 {
+			return ids[i-1], nil
+		}
+	}
+	return "", fmt.Errorf("no previous release available")
+}
+
+// flipCurrent atomically repoints the `current` symlink at releaseID by
+// creating a new symlink alongside it and renaming over the old one,
+// rather than removing and recreating `current` in place.
+func flipCurrent(deployLocation, releaseID string) error {
+	link := currentLink(deployLocation)
+	tmp := link + ".tmp"
+
+	os.Remove(tmp)
+	if err := os.Symlink(filepath.Join(releasesDirName, releaseID), tmp); err != nil {
+		return fmt.Errorf("failed to stage current symlink: %w", err)
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		return fmt.Errorf("failed to flip current symlink: %w", err)
+	}
+	return nil
+}
+
+// pruneReleases keeps the `keep` most recent releases and removes the
+// rest, skipping whichever release `current` points at even if it would
+// otherwise have aged out.
+func pruneReleases(deployLocation string, keep int) {
+	if keep <= 0 {
+		keep = defaultKeepCount
+	}
+
+	ids, err := sortedReleaseIDs(deployLocation)
+	if err != nil {
+		log.Printf("Error listing releases for pruning: %v", err)
+		return
+	}
+
+	active, _ := currentReleaseID(deployLocation)
+
+	excess := len(ids) - keep
+	for i := 0; i < excess; i++ {
+		if ids[i] == active {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(releasesDir(deployLocation), ids[i])); err != nil {
+			log.Printf("Error pruning release %s: %v", ids[i], err)
+		}
+	}
+}
+
+// hashDir returns a stable hash over the relative paths and contents of
+// every regular file under dir, used to detect when a source tree hasn't
+// actually changed between file events.
+func hashDir(dir string) (string, error) {
+	h := sha256.New()
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00", filepath.ToSlash(relPath))
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(h, f)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/grep-michael/GoDeployer/logstore"
+)
+
+// Runtime executes a deployed build and manages its lifecycle. Deployer
+// doesn't care whether the workload runs directly on the host or inside
+// a container - it only drives Start/Stop/Running.
+type Runtime interface {
+	// Start launches cfg.Executable/cfg.Args against the files in
+	// deployPath, sending its combined stdout/stderr to logs. ctx is
+	// the long-lived application context; the workload is not stopped
+	// when ctx is cancelled - Deployer calls Stop on shutdown.
+	Start(ctx context.Context, cfg *DeployConfig, deployPath string, logs *logstore.LogStore) error
+	// Stop asks the running workload to exit, waiting up to grace
+	// before forcing it to stop. Safe to call even if Start was never
+	// called or the workload already exited.
+	Stop(ctx context.Context, grace time.Duration) error
+	// Running reports whether the workload is currently up.
+	Running() bool
+}
+
+// newRuntime picks a Runtime implementation for cfg: ExecRuntime by
+// default, or DockerRuntime when an Image is configured.
+func newRuntime(cfg *DeployConfig) Runtime {
+	if cfg.Image != "" {
+		return NewDockerRuntime()
+	}
+	return NewExecRuntime()
+}
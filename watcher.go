@@ -1,11 +1,11 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
-	"os/signal"
 	"path/filepath"
-	"syscall"
+	"sync"
 	"time"
 )
 
@@ -13,6 +13,11 @@ type FileWatcher struct {
 	Path        string
 	Interval    time.Duration
 	subscribers []FileChangeHandler
+
+	ctx context.Context
+
+	knownMu sync.Mutex
+	known   map[string]FileState
 }
 type FileChangeEvent struct {
 	RelPath    string
@@ -27,11 +32,13 @@ type FileState struct {
 }
 type FileChangeHandler func(event FileChangeEvent)
 
-func NewFileWatcher(path string, interval time.Duration) *FileWatcher {
+func NewFileWatcher(ctx context.Context, path string, interval time.Duration) *FileWatcher {
 	return &FileWatcher{
 		Path:        path,
 		Interval:    interval,
 		subscribers: make([]FileChangeHandler, 0),
+		ctx:         ctx,
+		known:       make(map[string]FileState),
 	}
 }
 func (fw *FileWatcher) SetPath(path string) {
@@ -42,45 +49,79 @@ func (fw *FileWatcher) Subscribe(handler FileChangeHandler) {
 	log.Printf("Subscribed handler (total: %d)", len(fw.subscribers))
 }
 
-func (fw *FileWatcher) StartPolling() {
-
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-
-	done := make(chan bool)
+// Start runs the watcher until fw.ctx is cancelled or the backend stops
+// on its own. Callers are responsible for cleanup (e.g. unmounting the
+// share) once Start returns.
+func (fw *FileWatcher) Start() {
+	done := make(chan struct{})
 
 	go func() {
-		fw.pollloop()
-		done <- true
+		fw.run()
+		close(done)
 	}()
+
 	select {
-	case <-sigChan:
-		log.Println("\nReceived interrupt signal, shutting down...")
-		unmountShare(fw.Path)
-		os.Exit(0)
+	case <-fw.ctx.Done():
+		log.Println("Shutdown requested, stopping watcher...")
 	case <-done:
 		log.Println("Watch ended")
-		unmountShare(fw.Path)
 	}
 }
 
-func (fw *FileWatcher) pollloop() {
-	knownFiles := make(map[string]FileState)
+// run picks the best available backend for fw.Path: event-driven inotify
+// where the filesystem supports it, falling back to the poll loop for
+// network shares (SMB/CIFS) where inotify events don't propagate.
+func (fw *FileWatcher) run() {
+	if usesNetworkFilesystem(fw.Path) {
+		log.Printf("%s looks like a network share (CIFS/SMB); inotify events aren't reliable there, using polling", fw.Path)
+		fw.pollloop()
+		return
+	}
+
+	iw, err := newInotifyWatcher(fw.Path)
+	if err != nil {
+		log.Printf("inotify unavailable (%v), falling back to polling", err)
+		fw.pollloop()
+		return
+	}
+
+	log.Printf("Watching %s via inotify", fw.Path)
+	// Establish the baseline so the first real event can tell created
+	// apart from modified, same as the poll loop's initial scan.
+	fw.scanFiles(fw.Path, true)
 
+	// unix.Read on the inotify fd has no context support, so closing the
+	// fd on shutdown is what unblocks iw.run and lets it return.
+	go func() {
+		<-fw.ctx.Done()
+		iw.close()
+	}()
+	iw.run(fw)
+}
+
+func (fw *FileWatcher) pollloop() {
 	log.Printf("Starting to poll %s every %v", fw.Path, fw.Interval)
 
 	ticker := time.NewTicker(fw.Interval)
 	defer ticker.Stop()
 
 	// Do initial scan
-	fw.scanFiles(fw.Path, knownFiles, true)
+	fw.scanFiles(fw.Path, true)
 
-	for range ticker.C {
-		fw.scanFiles(fw.Path, knownFiles, false)
+	for {
+		select {
+		case <-fw.ctx.Done():
+			return
+		case <-ticker.C:
+			fw.scanFiles(fw.Path, false)
+		}
 	}
 }
 
-func (fw *FileWatcher) scanFiles(sharePath string, knownFiles map[string]FileState, isInitial bool) {
+func (fw *FileWatcher) scanFiles(sharePath string, isInitial bool) {
+	fw.knownMu.Lock()
+	defer fw.knownMu.Unlock()
+
 	currentFiles := make(map[string]FileState)
 
 	err := filepath.Walk(sharePath, func(path string, info os.FileInfo, err error) error {
@@ -109,7 +150,7 @@ func (fw *FileWatcher) scanFiles(sharePath string, knownFiles map[string]FileSta
 		currentFiles[relPath] = state
 
 		// Check if file is new or modified
-		if oldState, exists := knownFiles[relPath]; exists {
+		if oldState, exists := fw.known[relPath]; exists {
 			// Check if modified
 			if !oldState.ModTime.Equal(state.ModTime) || oldState.Size != state.Size {
 				log.Printf("MODIFIED: %s (size: %d bytes, modified: %s)",
@@ -146,7 +187,7 @@ func (fw *FileWatcher) scanFiles(sharePath string, knownFiles map[string]FileSta
 
 	// Check for deleted files
 	if !isInitial {
-		for relPath := range knownFiles {
+		for relPath := range fw.known {
 			if _, exists := currentFiles[relPath]; !exists {
 				log.Printf("DELETED: %s", relPath)
 				fw.Notify(FileChangeEvent{
@@ -158,15 +199,60 @@ func (fw *FileWatcher) scanFiles(sharePath string, knownFiles map[string]FileSta
 	}
 
 	// Update known files
-	for k, v := range currentFiles {
-		knownFiles[k] = v
-	}
+	fw.known = currentFiles
+}
+
+// refreshPath re-stats a single file relative to fw.Path and notifies
+// subscribers if it was created, modified or deleted since the last
+// known state. Used by the inotify backend once a path has settled
+// past the debounce window, instead of re-walking the whole tree.
+func (fw *FileWatcher) refreshPath(relPath string) {
+	fullPath := filepath.Join(fw.Path, relPath)
 
-	// Remove deleted files from map
-	for k := range knownFiles {
-		if _, exists := currentFiles[k]; !exists {
-			delete(knownFiles, k)
+	fw.knownMu.Lock()
+	defer fw.knownMu.Unlock()
+
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		if _, existed := fw.known[relPath]; existed {
+			log.Printf("DELETED: %s", relPath)
+			delete(fw.known, relPath)
+			fw.Notify(FileChangeEvent{
+				ChangeType: "deleted",
+				RelPath:    relPath,
+			})
 		}
+		return
+	}
+
+	if info.IsDir() {
+		return
+	}
+
+	state := FileState{ModTime: info.ModTime(), Size: info.Size()}
+	oldState, existed := fw.known[relPath]
+	fw.known[relPath] = state
+
+	switch {
+	case !existed:
+		log.Printf("NEW FILE: %s (size: %d bytes)", relPath, state.Size)
+		fw.Notify(FileChangeEvent{
+			ChangeType: "created",
+			RelPath:    relPath,
+			FullPath:   fullPath,
+			Size:       state.Size,
+			ModTime:    state.ModTime,
+		})
+	case !oldState.ModTime.Equal(state.ModTime) || oldState.Size != state.Size:
+		log.Printf("MODIFIED: %s (size: %d bytes, modified: %s)",
+			relPath, state.Size, state.ModTime.Format(time.RFC3339))
+		fw.Notify(FileChangeEvent{
+			ChangeType: "modified",
+			RelPath:    relPath,
+			FullPath:   fullPath,
+			Size:       state.Size,
+			ModTime:    state.ModTime,
+		})
 	}
 }
 
@@ -0,0 +1,171 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// cifsMagicNumber is the Statfs_t.Type value for CIFS mounts (see
+// linux/magic.h). SMB/CIFS shares generally don't propagate inotify
+// events to the client, so we use this to route those paths to the
+// poll backend instead.
+const cifsMagicNumber = 0xFF534D42
+
+// debounceWindow coalesces bursts of inotify events on the same
+// RelPath (e.g. the many IN_MODIFY events of one large write) into a
+// single notification for that file. Distinct files, such as those a
+// `cp -r` creates, each still get their own notification.
+const debounceWindow = 250 * time.Millisecond
+
+const inotifyMask = unix.IN_CREATE | unix.IN_MODIFY | unix.IN_DELETE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM
+
+// usesNetworkFilesystem reports whether path is on a CIFS/SMB mount,
+// where inotify events can't be relied on.
+func usesNetworkFilesystem(path string) bool {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return false
+	}
+	return uint32(stat.Type) == cifsMagicNumber
+}
+
+// inotifyWatcher recursively watches root for create/modify/delete/move
+// events, registering new watches as subdirectories appear.
+type inotifyWatcher struct {
+	fd        int
+	root      string
+	watches   map[int32]string // wd -> dir path
+	closeOnce sync.Once
+}
+
+func newInotifyWatcher(root string) (*inotifyWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("inotify_init: %w", err)
+	}
+
+	iw := &inotifyWatcher{
+		fd:      fd,
+		root:    root,
+		watches: make(map[int32]string),
+	}
+
+	if err := iw.addTree(root); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	return iw, nil
+}
+
+// addTree registers a watch on dir and every directory beneath it.
+func (iw *inotifyWatcher) addTree(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Printf("Error accessing path %s: %v", path, err)
+			return nil
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		return iw.addWatch(path)
+	})
+}
+
+func (iw *inotifyWatcher) addWatch(dir string) error {
+	wd, err := unix.InotifyAddWatch(iw.fd, dir, inotifyMask)
+	if err != nil {
+		return fmt.Errorf("inotify_add_watch %s: %w", dir, err)
+	}
+	iw.watches[int32(wd)] = dir
+	return nil
+}
+
+// close is called both by the ctx.Done() watcher in watcher.go (to
+// unblock the pending unix.Read) and by run's own deferred cleanup
+// once it returns, so it must tolerate being called twice.
+func (iw *inotifyWatcher) close() {
+	iw.closeOnce.Do(func() {
+		unix.Close(iw.fd)
+	})
+}
+
+// run reads raw inotify events off the fd, debounces them per RelPath,
+// and has fw re-check the settled path once the debounce window lapses.
+func (iw *inotifyWatcher) run(fw *FileWatcher) {
+	defer iw.close()
+
+	var mu sync.Mutex
+	timers := make(map[string]*time.Timer)
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+
+	for {
+		n, err := unix.Read(iw.fd, buf)
+		if err != nil {
+			log.Printf("inotify read error: %v", err)
+			return
+		}
+
+		offset := 0
+		for offset+unix.SizeofInotifyEvent <= n {
+			raw := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameLen := int(raw.Len)
+
+			var name string
+			if nameLen > 0 {
+				nameBytes := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+nameLen]
+				name = strings.TrimRight(string(nameBytes), "\x00")
+			}
+			offset += unix.SizeofInotifyEvent + nameLen
+
+			if name == "" {
+				continue
+			}
+			dir, ok := iw.watches[raw.Wd]
+			if !ok {
+				continue
+			}
+			fullPath := filepath.Join(dir, name)
+
+			// A new/renamed-in directory needs its own watch so we keep
+			// seeing events for files created inside it.
+			if raw.Mask&unix.IN_ISDIR != 0 {
+				if raw.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+					if err := iw.addTree(fullPath); err != nil {
+						log.Printf("Error watching new directory %s: %v", fullPath, err)
+					}
+				}
+				continue
+			}
+
+			relPath, err := filepath.Rel(iw.root, fullPath)
+			if err != nil {
+				log.Printf("Error getting relative path for %s: %v", fullPath, err)
+				continue
+			}
+
+			mu.Lock()
+			if t, pending := timers[relPath]; pending {
+				t.Stop()
+			}
+			timers[relPath] = time.AfterFunc(debounceWindow, func() {
+				mu.Lock()
+				delete(timers, relPath)
+				mu.Unlock()
+				fw.refreshPath(relPath)
+			})
+			mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// inotify is Linux-only; everywhere else we always fall back to the
+// poll loop.
+func usesNetworkFilesystem(path string) bool { return false }
+
+type inotifyWatcher struct{}
+
+func newInotifyWatcher(root string) (*inotifyWatcher, error) {
+	return nil, fmt.Errorf("inotify backend not supported on this platform")
+}
+
+func (iw *inotifyWatcher) run(fw *FileWatcher) {}
+func (iw *inotifyWatcher) close()              {}